@@ -0,0 +1,313 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cilium/hive/cell"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
+	"github.com/cilium/cilium/pkg/datapath/linux/sysctl"
+	"github.com/cilium/cilium/pkg/datapath/tunnel"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	mtuconst "github.com/cilium/cilium/pkg/mtu"
+)
+
+// deviceDriftDebounce is how long the reconciler waits after the last
+// observed link event before re-running setup. A burst of events (e.g.
+// several devices touched back to back by the same external tool) is
+// coalesced into a single reconciliation pass instead of one per event.
+const deviceDriftDebounce = 250 * time.Millisecond
+
+// deviceDriftTotal counts every time a managed device is found to have
+// drifted from its desired state and is reconciled.
+var deviceDriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "cilium_datapath_device_drift_total",
+	Help: "Number of times a managed datapath device was found to have drifted from its desired state and was reconciled",
+}, []string{"device", "field"})
+
+func init() {
+	prometheus.MustRegister(deviceDriftTotal)
+}
+
+// Cell provides the long-running reconciler that watches the devices set up
+// by setupBaseDevice, setupTunnelDevice and setupIPIPDevices, and re-applies
+// their setup whenever one of them is deleted or drifts out-of-band, e.g.
+// because node-level tooling (systemd-networkd, another CNI, an operator)
+// reset a sysctl or removed a tunnel device without the agent noticing.
+var Cell = cell.Module(
+	"datapath-device-reconciler",
+	"Reconciles managed datapath devices that drift from their desired state",
+
+	cell.Invoke(registerDeviceDriftReconciler),
+)
+
+// DeviceDriftConfig carries the subset of agent configuration the
+// reconciler needs in order to re-invoke device setup exactly the way
+// datapath initialization did on agent start.
+type DeviceDriftConfig struct {
+	MTU int
+
+	// NativeDevice is the configured direct-routing device, which may be a
+	// VLAN-tagged name (see setupBaseDevice).
+	NativeDevice string
+
+	TunnelMode        tunnel.EncapProtocol
+	TunnelPort        uint16
+	TunnelSrcPortLow  uint16
+	TunnelSrcPortHigh uint16
+
+	EnableIPv4, EnableIPv6 bool
+}
+
+// DeviceDriftReconcilerParams are the dependencies of the drift reconciler.
+type DeviceDriftReconcilerParams struct {
+	cell.In
+
+	Logger *slog.Logger
+	Sysctl sysctl.Sysctl
+	Config DeviceDriftConfig
+}
+
+func registerDeviceDriftReconciler(lc cell.Lifecycle, p DeviceDriftReconcilerParams) {
+	r := &deviceDriftReconciler{
+		logger: p.Logger,
+		sysctl: p.Sysctl,
+		config: p.Config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	lc.Append(cell.Hook{
+		OnStart: func(cell.HookContext) error {
+			return r.start()
+		},
+		OnStop: func(cell.HookContext) error {
+			close(r.stop)
+			<-r.done
+			return nil
+		},
+	})
+}
+
+// deviceDriftReconciler watches managedDevices for deletion or drift and
+// re-runs the setup function that owns each device.
+type deviceDriftReconciler struct {
+	logger *slog.Logger
+	sysctl sysctl.Sysctl
+	config DeviceDriftConfig
+
+	// prevNativeDevice is the NativeDevice passed to setupBaseDevice on the
+	// previous reconcile, so a Cilium-created VLAN sub-interface is cleaned
+	// up if the configured native device moves away from it.
+	prevNativeDevice string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// managedDevices is the set of link names the reconciler watches for.
+// Events on any other device are ignored. The configured native device is
+// only included when its name is a VLAN sub-interface name, which
+// setupBaseDevice creates if missing: a plain physical NIC isn't owned by
+// Cilium and its deletion isn't something to reconcile.
+func (r *deviceDriftReconciler) managedDevices() []string {
+	devices := []string{
+		defaults.HostDevice,
+		defaults.SecondHostDevice,
+		defaults.GeneveDevice,
+		defaults.VxlanDevice,
+		defaults.GREDevice,
+		defaults.IP6GREDevice,
+		defaults.IPIPv4Device,
+		defaults.IPIPv6Device,
+	}
+
+	if _, _, ok := parseVlanDevice(r.config.NativeDevice); ok {
+		devices = append(devices, r.config.NativeDevice)
+	}
+
+	return devices
+}
+
+func (r *deviceDriftReconciler) isManaged(name string) bool {
+	for _, d := range r.managedDevices() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *deviceDriftReconciler) start() error {
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribeWithOptions(updates, r.stop, netlink.LinkSubscribeOptions{
+		ErrorCallback: func(err error) {
+			r.logger.Warn("Error from link update subscription", logfields.Error, err)
+		},
+	}); err != nil {
+		return fmt.Errorf("subscribing to link updates: %w", err)
+	}
+
+	go r.run(updates)
+	return nil
+}
+
+func (r *deviceDriftReconciler) run(updates chan netlink.LinkUpdate) {
+	defer close(r.done)
+
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			if r.isManaged(u.Link.Attrs().Name) {
+				timer.Reset(deviceDriftDebounce)
+			}
+
+		case <-timer.C:
+			r.reconcile()
+
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reconcile records any observed drift on the managed devices and then
+// re-runs the setup functions that own them. The setup functions are
+// already idempotent (ensureDevice/enableForwarding only change what's
+// necessary), which is what makes it safe to call them here on every
+// debounced batch of events rather than only once at agent start.
+func (r *deviceDriftReconciler) reconcile() {
+	r.recordDrift(defaults.HostDevice, r.config.MTU, true)
+	r.recordDrift(defaults.SecondHostDevice, r.config.MTU, true)
+
+	if _, _, ok := parseVlanDevice(r.config.NativeDevice); ok {
+		r.recordDrift(r.config.NativeDevice, r.config.MTU, false)
+	}
+	if _, _, err := setupBaseDevice(r.logger, r.sysctl, r.config.MTU, r.prevNativeDevice, r.config.NativeDevice); err != nil {
+		r.logger.Warn("Failed to reconcile base device drift", logfields.Error, err)
+	}
+	r.prevNativeDevice = r.config.NativeDevice
+
+	switch r.config.TunnelMode {
+	case tunnel.Geneve:
+		r.recordDrift(defaults.GeneveDevice, r.config.MTU, false)
+	case tunnel.VXLAN:
+		r.recordDrift(defaults.VxlanDevice, r.config.MTU, false)
+	case tunnel.GRE:
+		r.recordDrift(defaults.GREDevice, r.config.MTU-mtuconst.GREOverhead, false)
+	case tunnel.IP6GRE:
+		r.recordDrift(defaults.IP6GREDevice, r.config.MTU-mtuconst.IP6GREOverhead, false)
+	}
+	if err := setupTunnelDevice(r.logger, r.sysctl, r.config.TunnelMode, r.config.TunnelPort, r.config.TunnelSrcPortLow, r.config.TunnelSrcPortHigh, r.config.MTU); err != nil {
+		r.logger.Warn("Failed to reconcile tunnel device drift", logfields.Error, err)
+	}
+
+	if r.config.EnableIPv4 {
+		r.recordDrift(defaults.IPIPv4Device, r.config.MTU-mtuconst.IPIPv4Overhead, false)
+	}
+	if r.config.EnableIPv6 {
+		r.recordDrift(defaults.IPIPv6Device, r.config.MTU-mtuconst.IPIPv6Overhead, false)
+	}
+	if r.config.EnableIPv4 || r.config.EnableIPv6 {
+		if err := setupIPIPDevices(r.logger, r.sysctl, r.config.EnableIPv4, r.config.EnableIPv6, r.config.MTU); err != nil {
+			r.logger.Warn("Failed to reconcile IPIP device drift", logfields.Error, err)
+		}
+	}
+}
+
+// sysctlCheck is one of the per-device forwarding sysctls enableForwarding
+// applies to base and tunnel devices, paired with the value the reconciler
+// expects to find. Any other value means something reset it out-of-band.
+type sysctlCheck struct {
+	field string
+	name  []string
+	want  string
+}
+
+func ipv4SysctlChecks(device string) []sysctlCheck {
+	return []sysctlCheck{
+		{"forwarding", []string{"net", "ipv4", "conf", device, "forwarding"}, "1"},
+		{"rp_filter", []string{"net", "ipv4", "conf", device, "rp_filter"}, "0"},
+		{"accept_local", []string{"net", "ipv4", "conf", device, "accept_local"}, "1"},
+		{"send_redirects", []string{"net", "ipv4", "conf", device, "send_redirects"}, "0"},
+	}
+}
+
+func ipv6SysctlChecks(device string) []sysctlCheck {
+	return []sysctlCheck{
+		{"forwarding", []string{"net", "ipv6", "conf", device, "forwarding"}, "1"},
+	}
+}
+
+// sysctlReader is the subset of sysctl.Sysctl recordDrift needs to observe
+// the current value of a setting. r.sysctl is expected to satisfy it; if it
+// doesn't, sysctl drift simply isn't reported, since there's nothing to read
+// it back with.
+type sysctlReader interface {
+	Read(name []string) (string, error)
+}
+
+// recordDrift increments deviceDriftTotal for device for every way it's
+// found to have drifted from its desired state: having disappeared, its
+// MTU no longer matching wantMTU, ARP having been re-enabled (only checked
+// when checkARP is set, i.e. for the base devices setupBaseDevice turns ARP
+// off on), or one of its forwarding/rp_filter/accept_local/send_redirects
+// sysctls having been flipped away from what enableForwarding sets it to.
+// The actual fix-up happens when the caller re-runs the owning setup
+// function; this only accounts for what changed.
+func (r *deviceDriftReconciler) recordDrift(device string, wantMTU int, checkARP bool) {
+	link, err := safenetlink.LinkByName(device)
+	if err != nil {
+		deviceDriftTotal.WithLabelValues(device, "deleted").Inc()
+		return
+	}
+
+	if wantMTU != 0 && link.Attrs().MTU != wantMTU {
+		deviceDriftTotal.WithLabelValues(device, "mtu").Inc()
+	}
+
+	if checkARP && link.Attrs().RawFlags&unix.IFF_NOARP == 0 {
+		deviceDriftTotal.WithLabelValues(device, "arp").Inc()
+	}
+
+	reader, ok := r.sysctl.(sysctlReader)
+	if !ok {
+		return
+	}
+
+	var checks []sysctlCheck
+	if r.config.EnableIPv4 {
+		checks = append(checks, ipv4SysctlChecks(device)...)
+	}
+	if r.config.EnableIPv6 {
+		checks = append(checks, ipv6SysctlChecks(device)...)
+	}
+
+	for _, c := range checks {
+		got, err := reader.Read(c.name)
+		if err != nil {
+			continue
+		}
+		if got != c.want {
+			deviceDriftTotal.WithLabelValues(device, c.field).Inc()
+		}
+	}
+}
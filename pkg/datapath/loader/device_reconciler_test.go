@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/cilium/pkg/defaults"
+)
+
+func TestDeviceDriftReconcilerManagedDevices(t *testing.T) {
+	base := []string{
+		defaults.HostDevice,
+		defaults.SecondHostDevice,
+		defaults.GeneveDevice,
+		defaults.VxlanDevice,
+		defaults.GREDevice,
+		defaults.IP6GREDevice,
+		defaults.IPIPv4Device,
+		defaults.IPIPv6Device,
+	}
+
+	t.Run("plain native device is not managed", func(t *testing.T) {
+		r := &deviceDriftReconciler{config: DeviceDriftConfig{NativeDevice: "eth0"}}
+		require.ElementsMatch(t, base, r.managedDevices())
+		require.False(t, r.isManaged("eth0"))
+	})
+
+	t.Run("VLAN native device is managed", func(t *testing.T) {
+		r := &deviceDriftReconciler{config: DeviceDriftConfig{NativeDevice: "eth0.100"}}
+		require.ElementsMatch(t, append(append([]string{}, base...), "eth0.100"), r.managedDevices())
+		require.True(t, r.isManaged("eth0.100"))
+	})
+
+	t.Run("unmanaged device", func(t *testing.T) {
+		r := &deviceDriftReconciler{config: DeviceDriftConfig{NativeDevice: "eth0"}}
+		require.False(t, r.isManaged("some_other_dev"))
+	})
+
+	for _, d := range base {
+		t.Run("managed: "+d, func(t *testing.T) {
+			r := &deviceDriftReconciler{config: DeviceDriftConfig{NativeDevice: "eth0"}}
+			require.True(t, r.isManaged(d))
+		})
+	}
+}
+
+// TestDeviceDriftReconcilerRecordDriftDeletedDevice exercises recordDrift
+// against a device name that can never exist, which is the one drift case
+// recordDrift can observe without needing a real netlink link or sysctl
+// tree: every path through recordDrift starts with a LinkByName lookup, and
+// a failed lookup short-circuits straight to the "deleted" label without
+// touching MTU, ARP or sysctl state.
+func TestDeviceDriftReconcilerRecordDriftDeletedDevice(t *testing.T) {
+	deviceDriftTotal.Reset()
+
+	r := &deviceDriftReconciler{config: DeviceDriftConfig{}}
+	const missing = "cilium_test_does_not_exist"
+
+	r.recordDrift(missing, 1500, true)
+
+	got := testutil.ToFloat64(deviceDriftTotal.WithLabelValues(missing, "deleted"))
+	require.Equal(t, float64(1), got)
+}
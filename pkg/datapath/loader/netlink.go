@@ -12,6 +12,7 @@ import (
 
 	"github.com/cilium/ebpf"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
 
 	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
@@ -69,7 +70,17 @@ func enableForwarding(logger *slog.Logger, sysctl sysctl.Sysctl, link netlink.Li
 	return nil
 }
 
-func setupVethPair(logger *slog.Logger, sysctl sysctl.Sysctl, name, peerName string) error {
+// setupVethPair creates the veth pair name/peerName if it doesn't already
+// exist and enables forwarding on both ends.
+//
+// peerNetnsPath is usually empty, in which case the peer is left in the
+// init namespace like today. When it is set, the peer end is instead moved
+// into that namespace via LinkSetNsFd and has forwarding enabled there
+// through enableForwardingInNetns, since by that point its
+// net.ipv{4,6}.conf.<ifname>.* sysctls live under that namespace's
+// /proc/sys, not init's. This is the case once the CNI plugin has already
+// pushed the peer into a pod netns.
+func setupVethPair(logger *slog.Logger, sysctl sysctl.Sysctl, name, peerName, peerNetnsPath string) error {
 	// Create the veth pair if it doesn't exist.
 	if _, err := safenetlink.LinkByName(name); err != nil {
 		hostMac, err := mac.GenerateRandMAC()
@@ -106,7 +117,25 @@ func setupVethPair(logger *slog.Logger, sysctl sysctl.Sysctl, name, peerName str
 	if err != nil {
 		return err
 	}
-	if err := enableForwarding(logger, sysctl, peer); err != nil {
+
+	if peerNetnsPath == "" {
+		if err := enableForwarding(logger, sysctl, peer); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	ns, err := netns.GetFromPath(peerNetnsPath)
+	if err != nil {
+		return fmt.Errorf("opening netns %s: %w", peerNetnsPath, err)
+	}
+	defer ns.Close()
+
+	if err := netlink.LinkSetNsFd(peer, int(ns)); err != nil {
+		return fmt.Errorf("moving %s into netns %s: %w", peerName, peerNetnsPath, err)
+	}
+
+	if err := enableForwardingInNetns(logger, sysctl, peer, peerNetnsPath); err != nil {
 		return err
 	}
 
@@ -117,11 +146,30 @@ func setupVethPair(logger *slog.Logger, sysctl sysctl.Sysctl, name, peerName str
 // the first step of datapath initialization, then performs the setup (and
 // creation, if needed) of those interfaces. It returns two links and an error.
 // By default, it sets up the veth pair - cilium_host and cilium_net.
-func setupBaseDevice(logger *slog.Logger, sysctl sysctl.Sysctl, mtu int) (netlink.Link, netlink.Link, error) {
-	if err := setupVethPair(logger, sysctl, defaults.HostDevice, defaults.SecondHostDevice); err != nil {
+//
+// If device names a VLAN sub-interface (e.g. "eth0.100"), its VLAN link is
+// created on top of the parent device and reconciled alongside the veth
+// pair, so nodes with trunked NICs don't need VLAN links pre-created
+// out-of-band. prevDevice is the device that was configured on the
+// previous call (empty on the first call); if it named a VLAN sub-interface
+// and the configuration has since moved to device, the stale Cilium-created
+// sub-interface is removed so switching native devices never leaves one
+// behind.
+func setupBaseDevice(logger *slog.Logger, sysctl sysctl.Sysctl, mtu int, prevDevice, device string) (netlink.Link, netlink.Link, error) {
+	if err := setupVethPair(logger, sysctl, defaults.HostDevice, defaults.SecondHostDevice, ""); err != nil {
 		return nil, nil, err
 	}
 
+	if err := reconcileVlanDevice(prevDevice, device); err != nil {
+		return nil, nil, fmt.Errorf("cleaning up previous VLAN device %s: %w", prevDevice, err)
+	}
+
+	if _, _, ok := parseVlanDevice(device); ok {
+		if _, err := setupVlanDevice(logger, sysctl, device, mtu); err != nil {
+			return nil, nil, fmt.Errorf("setting up VLAN device %s: %w", device, err)
+		}
+	}
+
 	linkHost, err := safenetlink.LinkByName(defaults.HostDevice)
 	if err != nil {
 		return nil, nil, err
@@ -185,30 +233,58 @@ func setupTunnelDevice(logger *slog.Logger, sysctl sysctl.Sysctl, mode tunnel.En
 		if err := setupGeneveDevice(logger, sysctl, port, srcPortLow, srcPortHigh, mtu); err != nil {
 			return fmt.Errorf("setting up geneve device: %w", err)
 		}
-		if err := removeDevice(defaults.VxlanDevice); err != nil {
-			return fmt.Errorf("removing %s: %w", defaults.VxlanDevice, err)
+		if err := removeOtherTunnelDevices(defaults.GeneveDevice); err != nil {
+			return err
 		}
 
 	case tunnel.VXLAN:
 		if err := setupVxlanDevice(logger, sysctl, port, srcPortLow, srcPortHigh, mtu); err != nil {
 			return fmt.Errorf("setting up vxlan device: %w", err)
 		}
-		if err := removeDevice(defaults.GeneveDevice); err != nil {
-			return fmt.Errorf("removing %s: %w", defaults.GeneveDevice, err)
+		if err := removeOtherTunnelDevices(defaults.VxlanDevice); err != nil {
+			return err
 		}
 
-	default:
-		if err := removeDevice(defaults.VxlanDevice); err != nil {
-			return fmt.Errorf("removing %s: %w", defaults.VxlanDevice, err)
+	case tunnel.GRE:
+		if err := setupGREDevice(logger, sysctl, mtu); err != nil {
+			return fmt.Errorf("setting up gre device: %w", err)
 		}
-		if err := removeDevice(defaults.GeneveDevice); err != nil {
-			return fmt.Errorf("removing %s: %w", defaults.GeneveDevice, err)
+		if err := removeOtherTunnelDevices(defaults.GREDevice); err != nil {
+			return err
+		}
+
+	case tunnel.IP6GRE:
+		if err := setupIP6GREDevice(logger, sysctl, mtu); err != nil {
+			return fmt.Errorf("setting up ip6gre device: %w", err)
+		}
+		if err := removeOtherTunnelDevices(defaults.IP6GREDevice); err != nil {
+			return err
+		}
+
+	default:
+		if err := removeOtherTunnelDevices(""); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// removeOtherTunnelDevices removes all managed tunnel devices except keep
+// (pass "" to remove all of them), so that switching tunnel modes doesn't
+// leave a stale device from the previous mode behind.
+func removeOtherTunnelDevices(keep string) error {
+	for _, dev := range []string{defaults.GeneveDevice, defaults.VxlanDevice, defaults.GREDevice, defaults.IP6GREDevice} {
+		if dev == keep {
+			continue
+		}
+		if err := removeDevice(dev); err != nil {
+			return fmt.Errorf("removing %s: %w", dev, err)
+		}
+	}
+	return nil
+}
+
 // setupGeneveDevice ensures the cilium_geneve device is created with the given
 // destination port and mtu.
 //
@@ -322,6 +398,69 @@ func setupVxlanDevice(logger *slog.Logger, sysctl sysctl.Sysctl, port, srcPortLo
 	return nil
 }
 
+// setupGREDevice ensures the cilium_gre device is created with the given
+// mtu.
+//
+// FlowBased sets IFLA_GRE_COLLECT_METADATA so bpf_skb_[gs]et_tunnel_key()
+// continues to work, the same way it does for the geneve and vxlan devices.
+func setupGREDevice(logger *slog.Logger, sysctl sysctl.Sysctl, mtu int) error {
+	mac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return err
+	}
+
+	dev := &netlink.Gretap{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:         defaults.GREDevice,
+			MTU:          mtu - mtuconst.GREOverhead,
+			HardwareAddr: net.HardwareAddr(mac),
+		},
+		FlowBased: true,
+	}
+
+	if _, err := ensureDevice(logger, sysctl, dev); err != nil {
+		return fmt.Errorf("creating gre device: %w", err)
+	}
+
+	// Rename fallback device created by potential kernel module load after
+	// creating the tunnel interface.
+	if err := renameDevice("gre0", "cilium_gre0"); err != nil {
+		return fmt.Errorf("renaming fallback device %s: %w", "gre0", err)
+	}
+
+	return nil
+}
+
+// setupIP6GREDevice ensures the cilium_ip6gre device is created with the
+// given mtu. See setupGREDevice for FlowBased.
+func setupIP6GREDevice(logger *slog.Logger, sysctl sysctl.Sysctl, mtu int) error {
+	mac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return err
+	}
+
+	dev := &netlink.Ip6gretap{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:         defaults.IP6GREDevice,
+			MTU:          mtu - mtuconst.IP6GREOverhead,
+			HardwareAddr: net.HardwareAddr(mac),
+		},
+		FlowBased: true,
+	}
+
+	if _, err := ensureDevice(logger, sysctl, dev); err != nil {
+		return fmt.Errorf("creating ip6gre device: %w", err)
+	}
+
+	// Rename fallback device created by potential kernel module load after
+	// creating the tunnel interface.
+	if err := renameDevice("ip6gre0", "cilium_ip6gre0"); err != nil {
+		return fmt.Errorf("renaming fallback device %s: %w", "ip6gre0", err)
+	}
+
+	return nil
+}
+
 // setupIPIPDevices ensures the specified v4 and/or v6 devices are created and
 // configured with their respective sysctls.
 //
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
+	"github.com/cilium/cilium/pkg/datapath/linux/sysctl"
+	"github.com/cilium/cilium/pkg/datapath/tunnel"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/hive/hivetest"
+	"github.com/cilium/cilium/pkg/testutils"
+	"github.com/cilium/cilium/pkg/testutils/netns"
+)
+
+// tunnelDevices is every device setupTunnelDevice can own. Used to assert
+// that only the device for the current mode is present after each switch.
+var tunnelDevices = []string{
+	defaults.GeneveDevice,
+	defaults.VxlanDevice,
+	defaults.GREDevice,
+	defaults.IP6GREDevice,
+}
+
+func deviceForTunnelMode(mode tunnel.EncapProtocol) string {
+	switch mode {
+	case tunnel.Geneve:
+		return defaults.GeneveDevice
+	case tunnel.VXLAN:
+		return defaults.VxlanDevice
+	case tunnel.GRE:
+		return defaults.GREDevice
+	case tunnel.IP6GRE:
+		return defaults.IP6GREDevice
+	}
+	return ""
+}
+
+// TestSetupTunnelDeviceModeSwitch exercises switching setupTunnelDevice
+// between Geneve, GRE and VXLAN and back to Geneve, and asserts that after
+// every switch only the device for the current mode exists. This guards
+// against the class of bug where switching modes leaves a stale tunnel
+// device from a previous mode behind.
+func TestSetupTunnelDeviceModeSwitch(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	logger := hivetest.Logger(t)
+	sctl := sysctl.NewDirectSysctl("/proc/sys")
+
+	netns.NewNetNS(t).Do(func() error {
+		modes := []tunnel.EncapProtocol{tunnel.Geneve, tunnel.GRE, tunnel.VXLAN, tunnel.Geneve}
+
+		for _, mode := range modes {
+			err := setupTunnelDevice(logger, sctl, mode, 8472, 0, 0, 1500)
+			require.NoError(t, err, "setupTunnelDevice(%s)", mode)
+
+			want := deviceForTunnelMode(mode)
+			_, err = safenetlink.LinkByName(want)
+			require.NoError(t, err, "expected %s to exist after switching to %s", want, mode)
+
+			for _, other := range tunnelDevices {
+				if other == want {
+					continue
+				}
+				_, err := safenetlink.LinkByName(other)
+				require.Error(t, err, "expected %s to have been removed after switching to %s", other, mode)
+			}
+		}
+
+		return nil
+	})
+}
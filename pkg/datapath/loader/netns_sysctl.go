@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
+	"github.com/cilium/cilium/pkg/datapath/linux/sysctl"
+	"github.com/cilium/cilium/pkg/datapath/tables"
+)
+
+// withNetNSPath locks the calling goroutine to its OS thread, switches that
+// thread into the network namespace at netnsPath for the duration of fn,
+// and restores the original namespace afterwards. This mirrors the approach
+// the CNI tuning plugin uses to adjust sysctls on behalf of an interface
+// that has already been moved into another namespace.
+//
+// If restoring the original namespace fails, the thread's namespace can no
+// longer be trusted, so the thread is deliberately never unlocked: per
+// runtime.LockOSThread's contract, a goroutine that exits without calling
+// UnlockOSThread takes its underlying OS thread down with it instead of
+// returning it to the scheduler's pool, which is what keeps some unrelated
+// goroutine from silently running in the wrong namespace afterwards.
+func withNetNSPath(netnsPath string, fn func() error) error {
+	runtime.LockOSThread()
+
+	origNS, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("getting current netns: %w", err)
+	}
+	defer origNS.Close()
+
+	targetNS, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("opening netns %s: %w", netnsPath, err)
+	}
+	defer targetNS.Close()
+
+	if err := unix.Setns(int(targetNS), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("entering netns %s: %w", netnsPath, err)
+	}
+
+	fnErr := fn()
+
+	if err := unix.Setns(int(origNS), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("restoring netns after entering %s: %w (thread abandoned)", netnsPath, err)
+	}
+
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+// netnsSysctl is a sysctl.Sysctl that reads and writes /proc/sys paths of
+// whatever network namespace the calling OS thread currently has entered.
+// It must only be used from within a thread already switched into the
+// target namespace (see withNetNSPath), and refuses any key outside the
+// net/ hierarchy, since that's the only portion of /proc/sys that's
+// namespaced; anything else would silently apply to the wrong namespace's
+// global settings.
+type netnsSysctl struct{}
+
+func (netnsSysctl) path(name []string) (string, error) {
+	if len(name) == 0 || name[0] != "net" {
+		return "", fmt.Errorf("refusing to apply non-namespaced sysctl %q", strings.Join(name, "/"))
+	}
+	return filepath.Join(append([]string{"/proc/sys"}, name...)...), nil
+}
+
+func (n netnsSysctl) Write(name []string, val string) error {
+	path, err := n.path(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(val), 0644)
+}
+
+func (n netnsSysctl) WriteInt(name []string, val int64) error {
+	return n.Write(name, strconv.FormatInt(val, 10))
+}
+
+func (n netnsSysctl) Enable(name []string) error {
+	return n.Write(name, "1")
+}
+
+func (n netnsSysctl) Disable(name []string) error {
+	return n.Write(name, "0")
+}
+
+func (n netnsSysctl) Read(name []string) (string, error) {
+	path, err := n.path(name)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (n netnsSysctl) ReadInt(name []string) (int64, error) {
+	val, err := n.Read(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func (n netnsSysctl) ApplySettings(settings []tables.Sysctl) error {
+	for _, s := range settings {
+		if err := n.Write(s.Name, s.Val); err != nil {
+			if s.IgnoreErr {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// enableForwardingInNetns behaves like enableForwarding, but applies the
+// sysctls from inside netnsPath instead of the init namespace, since link
+// has already been moved there and net.ipv{4,6}.conf.<ifname>.* now
+// resolves under that namespace's /proc/sys. sysctl is accepted for
+// signature symmetry with enableForwarding but isn't used: the namespaced
+// writes always go through netnsSysctl.
+func enableForwardingInNetns(logger *slog.Logger, sysctl sysctl.Sysctl, link netlink.Link, netnsPath string) error {
+	ifName := link.Attrs().Name
+
+	err := withNetNSPath(netnsPath, func() error {
+		l, err := safenetlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("looking up %s inside netns: %w", ifName, err)
+		}
+		return enableForwarding(logger, netnsSysctl{}, l)
+	})
+	if err != nil {
+		return fmt.Errorf("enabling forwarding on %s in netns %s: %w", ifName, netnsPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetnsSysctlPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     []string
+		want    string
+		wantErr bool
+	}{
+		{"namespaced key", []string{"net", "ipv4", "conf", "eth0", "forwarding"}, "/proc/sys/net/ipv4/conf/eth0/forwarding", false},
+		{"empty key", nil, "", true},
+		{"non-net key", []string{"kernel", "hostname"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := (netnsSysctl{}).path(tt.key)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, path)
+		})
+	}
+}
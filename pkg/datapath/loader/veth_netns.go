@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/mac"
+)
+
+// ErrPeerNameInUse is returned by SetupVethInNetns when peerName already
+// exists inside peerNS. Callers should retry with a different peerName.
+var ErrPeerNameInUse = errors.New("peer device name already exists in target netns")
+
+// SetupVethInNetns creates a veth pair and atomically hands the peer end
+// off into peerNS, returning both links. Unlike setupVethPair, it isn't
+// specific to cilium_host/cilium_net: it's a general-purpose primitive for
+// endpoint plumbing, modelled on containernetworking's ip.SetupVeth.
+//
+// The pair is created in the init namespace under name and a random
+// temporary peer name, since the caller's desired peerName (e.g. "eth0")
+// may already be taken by an unrelated link in init, even though it's free
+// inside peerNS. The temporary peer is then, in a single OS-thread-locked
+// section, moved into peerNS and renamed to peerName, with both ends
+// brought up and given mtu. If peerName already exists inside peerNS once
+// the peer has been moved there, the rename is aborted and ErrPeerNameInUse
+// is returned so the caller can retry with a new name.
+//
+// On any failure after the pair has been created, the whole pair is
+// deleted so callers never observe a half-configured veth.
+func SetupVethInNetns(logger *slog.Logger, name, peerName string, mtu int, peerNS string) (hostLink, peerLink netlink.Link, err error) {
+	tmpPeerName, err := randomIfaceName("tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return nil, nil, err
+	}
+	peerMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:         name,
+			HardwareAddr: net.HardwareAddr(hostMac),
+			MTU:          mtu,
+			TxQLen:       1000,
+		},
+		PeerName:         tmpPeerName,
+		PeerHardwareAddr: net.HardwareAddr(peerMac),
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return nil, nil, fmt.Errorf("creating veth pair %s/%s: %w", name, tmpPeerName, err)
+	}
+
+	ok := false
+	defer func() {
+		if !ok {
+			if err := removeDevice(name); err != nil {
+				logger.Warn("Failed to clean up veth pair after setup failure", logfields.Error, err, logfields.Device, name)
+			}
+		}
+	}()
+
+	hostLink, err = safenetlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving created device %s: %w", name, err)
+	}
+	if err := netlink.LinkSetMTU(hostLink, mtu); err != nil {
+		return nil, nil, fmt.Errorf("setting MTU on %s: %w", name, err)
+	}
+	if err := netlink.LinkSetUp(hostLink); err != nil {
+		return nil, nil, fmt.Errorf("setting %s up: %w", name, err)
+	}
+
+	tmpPeer, err := safenetlink.LinkByName(tmpPeerName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving created device %s: %w", tmpPeerName, err)
+	}
+
+	peerLink, err = movePeerIntoNetns(tmpPeer, peerName, mtu, peerNS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("handing off %s to netns %s as %s: %w", tmpPeerName, peerNS, peerName, err)
+	}
+
+	ok = true
+	return hostLink, peerLink, nil
+}
+
+// movePeerIntoNetns moves peer into peerNS, renames it to peerName, and
+// brings it up with mtu, all from a single OS-thread-locked section since
+// the link can only be addressed by name from within its own namespace
+// once moved.
+//
+// If restoring the thread's original namespace afterwards fails, the
+// thread's namespace can no longer be trusted, so it's deliberately never
+// unlocked: per runtime.LockOSThread's contract, a goroutine that exits
+// without calling UnlockOSThread takes its underlying OS thread down with
+// it instead of returning it to the scheduler's pool, which is what keeps
+// some unrelated goroutine from silently running in the wrong namespace
+// afterwards.
+func movePeerIntoNetns(peer netlink.Link, peerName string, mtu int, peerNS string) (netlink.Link, error) {
+	runtime.LockOSThread()
+
+	origNS, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("getting current netns: %w", err)
+	}
+	defer origNS.Close()
+
+	targetNS, err := netns.GetFromPath(peerNS)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("opening netns %s: %w", peerNS, err)
+	}
+	defer targetNS.Close()
+
+	if err := netlink.LinkSetNsFd(peer, int(targetNS)); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("moving into netns: %w", err)
+	}
+
+	if err := unix.Setns(int(targetNS), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("entering netns: %w", err)
+	}
+
+	link, linkErr := func() (netlink.Link, error) {
+		if _, err := safenetlink.LinkByName(peerName); err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPeerNameInUse, peerName)
+		}
+
+		l, err := safenetlink.LinkByName(peer.Attrs().Name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up moved device: %w", err)
+		}
+
+		if err := netlink.LinkSetName(l, peerName); err != nil {
+			return nil, fmt.Errorf("renaming to %s: %w", peerName, err)
+		}
+		l, err = safenetlink.LinkByName(peerName)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving renamed device %s: %w", peerName, err)
+		}
+
+		if err := netlink.LinkSetMTU(l, mtu); err != nil {
+			return nil, fmt.Errorf("setting MTU on %s: %w", peerName, err)
+		}
+		if err := netlink.LinkSetUp(l); err != nil {
+			return nil, fmt.Errorf("setting %s up: %w", peerName, err)
+		}
+
+		return l, nil
+	}()
+
+	if err := unix.Setns(int(origNS), unix.CLONE_NEWNET); err != nil {
+		return nil, fmt.Errorf("restoring netns after moving %s: %w (thread abandoned)", peerName, err)
+	}
+
+	runtime.UnlockOSThread()
+	return link, linkErr
+}
+
+// randomIfaceName generates a short random interface name with the given
+// prefix, used for the veth peer's temporary name in the init namespace
+// before it's moved and renamed inside its target netns.
+func randomIfaceName(prefix string) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generating random interface name: %w", err)
+	}
+	return fmt.Sprintf("%s%x", prefix, buf), nil
+}
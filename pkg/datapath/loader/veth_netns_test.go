@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+
+	"github.com/cilium/cilium/pkg/hive/hivetest"
+	"github.com/cilium/cilium/pkg/testutils"
+	"github.com/cilium/cilium/pkg/testutils/netns"
+)
+
+// TestSetupVethInNetnsPeerNameInUse asserts that SetupVethInNetns refuses to
+// clobber an existing link named peerName inside the target netns, returning
+// ErrPeerNameInUse instead, and that the veth pair it created in the init
+// namespace is rolled back rather than left behind.
+func TestSetupVethInNetnsPeerNameInUse(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	logger := hivetest.Logger(t)
+
+	netns.NewNetNS(t).Do(func() error {
+		peerNS := netns.NewNetNS(t)
+
+		err := peerNS.Do(func() error {
+			return netlink.LinkAdd(&netlink.Dummy{
+				LinkAttrs: netlink.LinkAttrs{Name: "taken"},
+			})
+		})
+		require.NoError(t, err)
+
+		_, _, err = SetupVethInNetns(logger, "vethtest", "taken", 1500, peerNS.Path())
+		require.ErrorIs(t, err, ErrPeerNameInUse)
+
+		_, err = netlink.LinkByName("vethtest")
+		require.Error(t, err, "expected veth pair to be rolled back after a failed handoff")
+
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/cilium/cilium/pkg/datapath/linux/safenetlink"
+	"github.com/cilium/cilium/pkg/datapath/linux/sysctl"
+)
+
+// vlanDeviceAlias is applied to the Alias of VLAN sub-interfaces created by
+// setupVlanDevice, so that removeVlanDevice can recognize and clean up only
+// the sub-interfaces Cilium itself created and leave any pre-existing,
+// out-of-band VLAN links alone.
+const vlanDeviceAlias = "cilium-vlan"
+
+// parseVlanDevice splits a device name of the form "<parent>.<vlan-id>"
+// (e.g. "eth0.100") into its parent interface name and VLAN tag. ok is
+// false if name doesn't carry a valid VLAN suffix, i.e. it isn't a
+// VLAN-tagged device name at all.
+func parseVlanDevice(name string) (parent string, vlanID int, ok bool) {
+	idx := strings.LastIndex(name, ".")
+	if idx <= 0 || idx == len(name)-1 {
+		return "", 0, false
+	}
+
+	tag, err := strconv.Atoi(name[idx+1:])
+	if err != nil || tag < 1 || tag > 4094 {
+		return "", 0, false
+	}
+
+	return name[:idx], tag, true
+}
+
+// setupVlanDevice ensures the VLAN sub-interface named device (e.g.
+// "eth0.100") exists on top of its parent link, tagged with the VLAN ID
+// parsed out of device's name, and is brought up with forwarding enabled.
+// The parent link must already exist; it is resolved via
+// safenetlink.LinkByName.
+func setupVlanDevice(logger *slog.Logger, sysctl sysctl.Sysctl, device string, mtu int) (netlink.Link, error) {
+	parentName, vlanID, ok := parseVlanDevice(device)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid VLAN device name", device)
+	}
+
+	parent, err := safenetlink.LinkByName(parentName)
+	if err != nil {
+		return nil, fmt.Errorf("looking up VLAN parent %s: %w", parentName, err)
+	}
+
+	link, err := safenetlink.LinkByName(device)
+	if err != nil {
+		vlan := &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:        device,
+				ParentIndex: parent.Attrs().Index,
+				MTU:         mtu,
+				Alias:       vlanDeviceAlias,
+			},
+			VlanId: vlanID,
+		}
+		if err := netlink.LinkAdd(vlan); err != nil {
+			return nil, fmt.Errorf("creating VLAN device %s on %s: %w", device, parentName, err)
+		}
+
+		link, err = safenetlink.LinkByName(device)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving created VLAN device %s: %w", device, err)
+		}
+	}
+
+	if wantMTU, gotMTU := mtu, link.Attrs().MTU; wantMTU != 0 && wantMTU != gotMTU {
+		if err := netlink.LinkSetMTU(link, wantMTU); err != nil {
+			return nil, fmt.Errorf("setting MTU on VLAN device %s: %w", device, err)
+		}
+	}
+
+	if err := enableForwarding(logger, sysctl, link); err != nil {
+		return nil, fmt.Errorf("setting up VLAN device %s: %w", device, err)
+	}
+
+	return link, nil
+}
+
+// removeVlanDevice deletes the VLAN sub-interface named device. It refuses
+// to touch anything that isn't a VLAN sub-interface created by
+// setupVlanDevice: a link with ParentIndex == 0 is a physical (or
+// otherwise non-VLAN) device and a link missing the vlanDeviceAlias may
+// have been created out-of-band, so neither is ever removed here. It is a
+// no-op if the device doesn't exist.
+func removeVlanDevice(device string) error {
+	link, err := safenetlink.LinkByName(device)
+	if err != nil {
+		return nil
+	}
+
+	if !isRemovableVlanDevice(link) {
+		return nil
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("removing VLAN device %s: %w", device, err)
+	}
+
+	return nil
+}
+
+// isRemovableVlanDevice reports whether link is a VLAN sub-interface created
+// by setupVlanDevice, i.e. one removeVlanDevice may delete.
+func isRemovableVlanDevice(link netlink.Link) bool {
+	vlan, ok := link.(*netlink.Vlan)
+	return ok && vlan.ParentIndex != 0 && vlan.Alias == vlanDeviceAlias
+}
+
+// reconcileVlanDevice removes the previously configured VLAN sub-interface
+// when the native device configuration changes away from it, so switching
+// native devices doesn't leave Cilium-created VLAN links behind. It is a
+// no-op if prevDevice isn't a VLAN device name or hasn't changed.
+func reconcileVlanDevice(prevDevice, device string) error {
+	if prevDevice == "" || prevDevice == device {
+		return nil
+	}
+
+	if _, _, ok := parseVlanDevice(prevDevice); !ok {
+		return nil
+	}
+
+	return removeVlanDevice(prevDevice)
+}
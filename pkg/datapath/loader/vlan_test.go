@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package loader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseVlanDevice(t *testing.T) {
+	tests := []struct {
+		name       string
+		device     string
+		wantParent string
+		wantVlanID int
+		wantOK     bool
+	}{
+		{"valid", "eth0.100", "eth0", 100, true},
+		{"min tag", "eth0.1", "eth0", 1, true},
+		{"max tag", "eth0.4094", "eth0", 4094, true},
+		{"no dot", "eth0", "", 0, false},
+		{"leading dot", ".100", "", 0, false},
+		{"trailing dot", "eth0.", "", 0, false},
+		{"non-numeric tag", "eth0.abc", "", 0, false},
+		{"tag zero", "eth0.0", "", 0, false},
+		{"tag too large", "eth0.4095", "", 0, false},
+		{"negative tag", "eth0.-1", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, vlanID, ok := parseVlanDevice(tt.device)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Equal(t, tt.wantParent, parent)
+				require.Equal(t, tt.wantVlanID, vlanID)
+			}
+		})
+	}
+}
+
+// TestRemoveVlanDeviceRefusesNonVlanParentIndex asserts removeVlanDevice's
+// stated safety invariant directly against isRemovableVlanDevice, the check
+// removeVlanDevice uses, without needing an actual netlink link: a
+// *netlink.Vlan with ParentIndex == 0 must never be treated as eligible for
+// deletion, even when it carries vlanDeviceAlias.
+func TestRemoveVlanDeviceRefusesNonVlanParentIndex(t *testing.T) {
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: "eth0.100", Alias: vlanDeviceAlias},
+		VlanId:    100,
+	}
+
+	require.Equal(t, 0, vlan.Attrs().ParentIndex, "precondition: ParentIndex must be zero-valued")
+	require.False(t, isRemovableVlanDevice(vlan), "a link with ParentIndex == 0 must be recognized as ineligible for removal")
+}
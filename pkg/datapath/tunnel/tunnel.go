@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package tunnel
+
+// EncapProtocol is the encapsulation protocol used to tunnel traffic
+// between nodes.
+type EncapProtocol string
+
+const (
+	// Disabled disables tunneling; native routing is used instead.
+	Disabled EncapProtocol = ""
+	// Geneve tunnels traffic over a Geneve device (cilium_geneve).
+	Geneve EncapProtocol = "geneve"
+	// VXLAN tunnels traffic over a VXLAN device (cilium_vxlan).
+	VXLAN EncapProtocol = "vxlan"
+	// GRE tunnels traffic over a GRE device (cilium_gre).
+	GRE EncapProtocol = "gre"
+	// IP6GRE tunnels traffic over an IP6GRE device (cilium_ip6gre).
+	IP6GRE EncapProtocol = "ip6gre"
+)
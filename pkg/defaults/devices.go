@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package defaults
+
+// Names of the virtual devices the datapath creates and manages.
+const (
+	// HostDevice is the name of the veth device facing the init namespace,
+	// the other end of SecondHostDevice.
+	HostDevice = "cilium_host"
+	// SecondHostDevice is the name of the veth peer of HostDevice.
+	SecondHostDevice = "cilium_net"
+
+	// GeneveDevice is the name of the tunnel device used in Geneve mode.
+	GeneveDevice = "cilium_geneve"
+	// VxlanDevice is the name of the tunnel device used in VXLAN mode.
+	VxlanDevice = "cilium_vxlan"
+	// GREDevice is the name of the tunnel device used in GRE mode.
+	GREDevice = "cilium_gre"
+	// IP6GREDevice is the name of the tunnel device used in IP6GRE mode.
+	IP6GREDevice = "cilium_ip6gre"
+
+	// IPIPv4Device is the name of the IPv4 IPIP tunnel device.
+	IPIPv4Device = "cilium_ipip4"
+	// IPIPv6Device is the name of the IPv6 IPIP tunnel device.
+	IPIPv6Device = "cilium_ipip6"
+)
@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package mtu
+
+// Overhead, in bytes, added by each tunnel encapsulation this package's
+// users subtract from the node's MTU when creating tunnel devices.
+const (
+	// IPIPv4Overhead is the overhead added by IPv4 IPIP encapsulation.
+	IPIPv4Overhead = 20
+	// IPIPv6Overhead is the overhead added by IPv6 IPIP (ip6tnl)
+	// encapsulation.
+	IPIPv6Overhead = 40
+	// GREOverhead is the overhead added by GRE encapsulation (outer IPv4
+	// header plus the GRE header).
+	GREOverhead = 24
+	// IP6GREOverhead is the overhead added by IP6GRE encapsulation (outer
+	// IPv6 header plus the GRE header).
+	IP6GREOverhead = 44
+)